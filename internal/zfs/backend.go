@@ -0,0 +1,52 @@
+package zfs
+
+import "context"
+
+// Backend abstracts the calls zsys makes against the underlying ZFS
+// implementation for a single dataset: property get/set, children
+// enumeration, clone/promote/snapshot/destroy, mount/unmount and clone
+// discovery. Bookmark creation, diff, send and receive aren't part of it:
+// they always shell out to zfs(8), whichever backend is built. It exists so the property and state machinery in this package
+// (RefreshProperties, newDatasetTree, stringToProp, setProperty, …) can run
+// against either the cgo bicomsystems/go-libzfs bindings or a CLI
+// implementation that shells out to zfs(8)/zpool(8), selected at build time.
+//
+// This keeps zsys buildable in environments where linking libzfs is
+// impractical (containers, initramfs, a kernel module ahead of the vendored
+// libzfs), and lets unit tests mock the backend instead of a real pool.
+type Backend interface {
+	// DatasetProperty returns the value and source of a native or user
+	// property on name.
+	DatasetProperty(ctx context.Context, name, prop string) (value, source string, err error)
+	// SetDatasetProperty sets a native property to value on name.
+	SetDatasetProperty(ctx context.Context, name, prop, value string) error
+	// SetDatasetUserProperty sets a user property to value on name.
+	SetDatasetUserProperty(ctx context.Context, name, prop, value string) error
+	// Children returns the names of the direct children of name.
+	Children(ctx context.Context, name string) ([]string, error)
+	// Clones returns the names of the clones of the snapshot name.
+	Clones(ctx context.Context, name string) ([]string, error)
+	// Clone creates cloneName as a clone of the snapshot name.
+	Clone(ctx context.Context, name, cloneName string) error
+	// Promote promotes the clone name, inverting its origin relationship
+	// with the dataset it was cloned from.
+	Promote(ctx context.Context, name string) error
+	// Snapshot creates a snapshot named name ("dataset@snapshot").
+	Snapshot(ctx context.Context, name string) error
+	// Destroy destroys name, optionally recursive.
+	Destroy(ctx context.Context, name string, recursive bool) error
+	// Mount mounts name with the given mount options.
+	Mount(ctx context.Context, name, options string) error
+	// Unmount unmounts name.
+	Unmount(ctx context.Context, name string) error
+	// Inherit reverts prop on name to its inherited (or default, if name is
+	// the topmost dataset carrying it) value, optionally recursive.
+	Inherit(ctx context.Context, name, prop string, recursive bool) error
+	// PoolRoot returns the altroot of pool, used to derive dataset-relative
+	// mountpoints the same way zfs(8) does.
+	PoolRoot(ctx context.Context, pool string) (string, error)
+}
+
+// currentBackend is the Backend implementation selected for this build: see
+// backend_libzfs.go (default, cgo) and backend_cli.go (build tag "nocgo").
+var currentBackend Backend
@@ -0,0 +1,166 @@
+//go:build nocgo
+// +build nocgo
+
+package zfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/ubuntu/zsys/internal/i18n"
+)
+
+func init() {
+	currentBackend = cliBackend{}
+}
+
+// cliBackend implements Backend by shelling out to zfs(8)/zpool(8) and
+// parsing their "-Hpo" tab-separated output, the way mistifyio/go-zfs and
+// LXD's zfs storage driver do. It needs no cgo and doesn't link libzfs,
+// trading a process fork per call for running in environments (containers,
+// initramfs, a kernel module ahead of the vendored libzfs) where linking
+// libzfs is impractical.
+type cliBackend struct{}
+
+// runZFS execs "zfs <args…>" and returns its trimmed stdout, or an error
+// wrapping stderr on failure.
+func runZFS(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "zfs", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %v", strings.TrimSpace(stderr.String()), err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func (cliBackend) DatasetProperty(ctx context.Context, name, prop string) (value, source string, err error) {
+	out, err := runZFS(ctx, "get", "-Hpo", "value,source", prop, name)
+	if err != nil {
+		return "", "", fmt.Errorf(i18n.G("can't get %q property on %q: %v"), prop, name, err)
+	}
+	fields := strings.SplitN(out, "\t", 2)
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf(i18n.G("unexpected output for %q property on %q: %q"), prop, name, out)
+	}
+	return fields[0], fields[1], nil
+}
+
+func (cliBackend) SetDatasetProperty(ctx context.Context, name, prop, value string) error {
+	_, err := runZFS(ctx, "set", fmt.Sprintf("%s=%s", prop, value), name)
+	return err
+}
+
+func (cliBackend) SetDatasetUserProperty(ctx context.Context, name, prop, value string) error {
+	_, err := runZFS(ctx, "set", fmt.Sprintf("%s=%s", prop, value), name)
+	return err
+}
+
+func (cliBackend) Children(ctx context.Context, name string) ([]string, error) {
+	// zfs list defaults to -t filesystem,volume: without spelling out every
+	// type we'd silently drop snapshots and bookmarks, which newDatasetTree's
+	// recursive walk relies on Children to surface exactly like the libzfs
+	// backend's Children does.
+	out, err := runZFS(ctx, "list", "-Hpo", "name", "-r", "-d", "1", "-t", "filesystem,volume,snapshot,bookmark", name)
+	if err != nil {
+		return nil, fmt.Errorf(i18n.G("can't list children of %q: %v"), name, err)
+	}
+	var names []string
+	for _, line := range strings.Split(out, "\n") {
+		if line == "" || line == name {
+			continue
+		}
+		names = append(names, line)
+	}
+	return names, nil
+}
+
+func (cliBackend) Clones(ctx context.Context, name string) ([]string, error) {
+	out, err := runZFS(ctx, "get", "-Hpo", "value", "clones", name)
+	if err != nil {
+		return nil, fmt.Errorf(i18n.G("can't list clones of %q: %v"), name, err)
+	}
+	if out == "" || out == "-" {
+		return nil, nil
+	}
+	return strings.Split(out, ","), nil
+}
+
+func (cliBackend) Clone(ctx context.Context, name, cloneName string) error {
+	_, err := runZFS(ctx, "clone", name, cloneName)
+	return err
+}
+
+func (cliBackend) Promote(ctx context.Context, name string) error {
+	_, err := runZFS(ctx, "promote", name)
+	return err
+}
+
+func (cliBackend) Snapshot(ctx context.Context, name string) error {
+	_, err := runZFS(ctx, "snapshot", name)
+	return err
+}
+
+func (cliBackend) Destroy(ctx context.Context, name string, recursive bool) error {
+	args := []string{"destroy"}
+	if recursive {
+		args = append(args, "-r")
+	}
+	args = append(args, name)
+	_, err := runZFS(ctx, args...)
+	return err
+}
+
+func (cliBackend) Mount(ctx context.Context, name, options string) error {
+	args := []string{"mount"}
+	if options != "" {
+		args = append(args, "-o", options)
+	}
+	args = append(args, name)
+	_, err := runZFS(ctx, args...)
+	return err
+}
+
+func (cliBackend) Unmount(ctx context.Context, name string) error {
+	_, err := runZFS(ctx, "unmount", name)
+	return err
+}
+
+func (cliBackend) Inherit(ctx context.Context, name, prop string, recursive bool) error {
+	args := []string{"inherit"}
+	if recursive {
+		args = append(args, "-r")
+	}
+	args = append(args, prop, name)
+	_, err := runZFS(ctx, args...)
+	return err
+}
+
+// PoolRoot returns the altroot of pool, or "" if none is set.
+func (cliBackend) PoolRoot(ctx context.Context, pool string) (string, error) {
+	out, err := runZPool(ctx, "get", "-Hpo", "value", "altroot", pool)
+	if err != nil {
+		return "", fmt.Errorf(i18n.G("can't get altroot of pool %q: %v"), pool, err)
+	}
+	if out == "-" {
+		return "", nil
+	}
+	return out, nil
+}
+
+// runZPool execs "zpool <args…>" and returns its trimmed stdout, or an error
+// wrapping stderr on failure.
+func runZPool(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "zpool", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %v", strings.TrimSpace(stderr.String()), err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
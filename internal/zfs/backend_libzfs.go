@@ -0,0 +1,235 @@
+//go:build !nocgo
+// +build !nocgo
+
+package zfs
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+
+	libzfs "github.com/bicomsystems/go-libzfs"
+	"github.com/ubuntu/zsys/internal/i18n"
+)
+
+func init() {
+	currentBackend = libzfsBackend{}
+}
+
+// libzfsBackend implements Backend on top of the cgo bicomsystems/go-libzfs
+// bindings. It's the default backend: it requires linking against libzfs,
+// but talks to the kernel module directly instead of shelling out.
+type libzfsBackend struct{}
+
+// TODO: this reopens the dataset for every call, which is wasteful. There's
+// no longer a cached libzfs.Dataset handle anywhere in this package to reuse
+// instead (RefreshProperties and newDatasetTree go through Backend like
+// everything else now) — a real fix would need its own short-lived cache
+// here, scoped to a single tree scan.
+func openDataset(name string) (libzfs.Dataset, error) {
+	return libzfs.DatasetOpen(name)
+}
+
+func (libzfsBackend) DatasetProperty(ctx context.Context, name, prop string) (value, source string, err error) {
+	d, err := openDataset(name)
+	if err != nil {
+		return "", "", fmt.Errorf(i18n.G("couldn't open %q: %v"), name, err)
+	}
+	defer d.Close()
+
+	// "type" isn't in Properties: go-libzfs surfaces it as its own Dataset
+	// field rather than a libzfs.Property, so it has no "source" either.
+	if prop == "type" {
+		return datasetTypeString(d), "-", nil
+	}
+
+	if np, ok := nativeProp(prop); ok {
+		p := d.Properties[np]
+		return p.Value, p.Source, nil
+	}
+
+	p, err := d.GetUserProperty(prop)
+	if err != nil {
+		return "", "", fmt.Errorf(i18n.G("can't get %q property on %q: %v"), prop, name, err)
+	}
+	return p.Value, p.Source, nil
+}
+
+// datasetTypeString maps d's libzfs.DatasetType to the same "filesystem",
+// "volume", "snapshot" or "bookmark" strings zfs(8) itself prints for its
+// "type" property, so callers can treat both backends the same way.
+func datasetTypeString(d libzfs.Dataset) string {
+	switch d.Type {
+	case libzfs.DatasetTypeVolume:
+		return "volume"
+	case libzfs.DatasetTypeSnapshot:
+		return "snapshot"
+	case libzfs.DatasetTypeBookmark:
+		return "bookmark"
+	default:
+		return "filesystem"
+	}
+}
+
+func (libzfsBackend) SetDatasetProperty(ctx context.Context, name, prop, value string) error {
+	d, err := openDataset(name)
+	if err != nil {
+		return fmt.Errorf(i18n.G("couldn't open %q: %v"), name, err)
+	}
+	defer d.Close()
+
+	np, ok := nativeProp(prop)
+	if !ok {
+		return fmt.Errorf(i18n.G("%q isn't a native property"), prop)
+	}
+	return d.SetProperty(np, value)
+}
+
+func (libzfsBackend) SetDatasetUserProperty(ctx context.Context, name, prop, value string) error {
+	d, err := openDataset(name)
+	if err != nil {
+		return fmt.Errorf(i18n.G("couldn't open %q: %v"), name, err)
+	}
+	defer d.Close()
+
+	return d.SetUserProperty(prop, value)
+}
+
+func (libzfsBackend) Children(ctx context.Context, name string) ([]string, error) {
+	d, err := openDataset(name)
+	if err != nil {
+		return nil, fmt.Errorf(i18n.G("couldn't open %q: %v"), name, err)
+	}
+	defer d.Close()
+
+	var names []string
+	for _, c := range d.Children {
+		names = append(names, c.Properties[libzfs.DatasetPropName].Value)
+	}
+	return names, nil
+}
+
+func (libzfsBackend) Clones(ctx context.Context, name string) ([]string, error) {
+	d, err := openDataset(name)
+	if err != nil {
+		return nil, fmt.Errorf(i18n.G("couldn't open %q: %v"), name, err)
+	}
+	defer d.Close()
+
+	return d.Clones()
+}
+
+func (libzfsBackend) Clone(ctx context.Context, name, cloneName string) error {
+	d, err := openDataset(name)
+	if err != nil {
+		return fmt.Errorf(i18n.G("couldn't open %q: %v"), name, err)
+	}
+	defer d.Close()
+
+	props := make(map[libzfs.Prop]libzfs.Property)
+	_, err = d.Clone(cloneName, props)
+	return err
+}
+
+func (libzfsBackend) Promote(ctx context.Context, name string) error {
+	d, err := openDataset(name)
+	if err != nil {
+		return fmt.Errorf(i18n.G("couldn't open %q: %v"), name, err)
+	}
+	defer d.Close()
+
+	return d.Promote()
+}
+
+func (libzfsBackend) Snapshot(ctx context.Context, name string) error {
+	_, err := libzfs.DatasetSnapshot(name, false, nil)
+	return err
+}
+
+func (libzfsBackend) Destroy(ctx context.Context, name string, recursive bool) error {
+	d, err := openDataset(name)
+	if err != nil {
+		return fmt.Errorf(i18n.G("couldn't open %q: %v"), name, err)
+	}
+	defer d.Close()
+
+	if recursive {
+		return d.DestroyRecursive()
+	}
+	return d.Destroy(false)
+}
+
+func (libzfsBackend) Mount(ctx context.Context, name, options string) error {
+	d, err := openDataset(name)
+	if err != nil {
+		return fmt.Errorf(i18n.G("couldn't open %q: %v"), name, err)
+	}
+	defer d.Close()
+
+	return d.Mount(options, 0)
+}
+
+func (libzfsBackend) Unmount(ctx context.Context, name string) error {
+	d, err := openDataset(name)
+	if err != nil {
+		return fmt.Errorf(i18n.G("couldn't open %q: %v"), name, err)
+	}
+	defer d.Close()
+
+	return d.UnmountAll(0)
+}
+
+// Inherit reverts prop on name to its inherited value.
+//
+// TODO: go-libzfs doesn't wrap zfs_prop_inherit/zfs_prop_inherit_source, so
+// there's no cgo call we can make here. Shell out to zfs(8) as a stopgap
+// until a small cgo shim around zfs_prop_inherit lands in
+// bicomsystems/go-libzfs; this is the one Backend operation the libzfs
+// backend doesn't implement purely through cgo.
+func (libzfsBackend) Inherit(ctx context.Context, name, prop string, recursive bool) error {
+	args := []string{"inherit"}
+	if recursive {
+		args = append(args, "-r")
+	}
+	args = append(args, prop, name)
+	cmd := exec.CommandContext(ctx, "zfs", args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf(i18n.G("couldn't inherit %q on %q: %s: %v"), prop, name, out, err)
+	}
+	return nil
+}
+
+// PoolRoot returns the altroot of pool, or "" if none is set.
+func (libzfsBackend) PoolRoot(ctx context.Context, pool string) (string, error) {
+	p, err := libzfs.PoolOpen(pool)
+	if err != nil {
+		return "", fmt.Errorf(i18n.G("couldn't open pool %q: %v"), pool, err)
+	}
+	defer p.Close()
+
+	root := p.Properties[libzfs.PoolPropAltroot].Value
+	if root == "-" {
+		return "", nil
+	}
+	return root, nil
+}
+
+// nativeProp maps a zsys property name to its libzfs.Prop, when it's backed
+// by a native property rather than a "com.ubuntu.zsys:*" user property.
+func nativeProp(prop string) (libzfs.Prop, bool) {
+	switch prop {
+	case CanmountProp:
+		return libzfs.DatasetPropCanmount, true
+	case MountPointProp:
+		return libzfs.DatasetPropMountpoint, true
+	case "mounted":
+		return libzfs.DatasetPropMounted, true
+	case "origin":
+		return libzfs.DatasetPropOrigin, true
+	case "creation":
+		return libzfs.DatasetPropCreation, true
+	default:
+		return 0, false
+	}
+}
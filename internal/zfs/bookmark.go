@@ -0,0 +1,121 @@
+package zfs
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+
+	"github.com/ubuntu/zsys/internal/config"
+	"github.com/ubuntu/zsys/internal/i18n"
+	"github.com/ubuntu/zsys/internal/log"
+)
+
+// Kind identifies what a Dataset actually is: a regular filesystem dataset,
+// a snapshot, or a bookmark.
+type Kind int
+
+const (
+	// KindFilesystem is a regular, mountable ZFS filesystem dataset.
+	KindFilesystem Kind = iota
+	// KindSnapshot is a read-only point-in-time snapshot of a filesystem dataset.
+	KindSnapshot
+	// KindBookmark is a lightweight bookmark: it has no data of its own, but
+	// anchors the point in time a snapshot was taken so that an incremental
+	// send can still use it as a "from" reference after the snapshot it was
+	// created from has been destroyed.
+	KindBookmark
+)
+
+// CreateBookmark creates a bookmark named bookmarkName anchored on
+// snapshotName, and copies our "com.ubuntu.zsys:*" user properties
+// (BootfsProp, LastUsedProp, BootfsDatasets) over from the snapshot, since a
+// bookmark has no properties of its own until we set them. This lets zsys
+// age out a boot environment's snapshots while keeping a cheap anchor for
+// future incremental sends.
+func (z *Zfs) CreateBookmark(ctx context.Context, snapshotName, bookmarkName string) (err error) {
+	log.Debugf(ctx, i18n.G("Creating bookmark %q from %q"), bookmarkName, snapshotName)
+
+	snap, err := z.findDatasetByName(snapshotName)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "zfs", "bookmark", snapshotName, bookmarkName)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf(i18n.G("couldn't create bookmark %q from %q: ")+config.ErrorFormat, bookmarkName, snapshotName, fmt.Errorf("%s: %v", out, err))
+	}
+
+	bookmark, err := newDatasetTree(ctx, bookmarkName, &z.allDatasets)
+	if err != nil {
+		return err
+	}
+
+	for _, prop := range []string{BootfsProp, LastUsedProp, BootfsDatasetsProp} {
+		value, source := snap.getPropertyFromName(prop)
+		if value == "" {
+			continue
+		}
+		if err := bookmark.freezeProperty(ctx, prop, value, source); err != nil {
+			return fmt.Errorf(i18n.G("couldn't copy %q onto bookmark %q: ")+config.ErrorFormat, prop, bookmarkName, err)
+		}
+	}
+
+	return nil
+}
+
+// freezeProperty writes value and source verbatim as name's
+// "com.ubuntu.zsys:*" user property on the bookmark, exactly how it reads on
+// the snapshot right now. Unlike setProperty, it never redirects a source of
+// "inherited" through InheritProperty: a bookmark has nothing to inherit
+// from, so that redirect would just discard the value CreateBookmark is
+// trying to preserve.
+func (d *Dataset) freezeProperty(ctx context.Context, name, value, source string) error {
+	_, propName, destV, destS := d.stringToProp(name)
+
+	if err := currentBackend.SetDatasetUserProperty(ctx, d.Name, propName, fmt.Sprintf("%s:%s", value, source)); err != nil {
+		return err
+	}
+
+	*destV = value
+	*destS = source
+	return nil
+}
+
+// refreshBookmarkProperties populates the subset of DatasetProp that makes
+// sense for a bookmark: our own "com.ubuntu.zsys:*" user properties, which
+// are preserved on a bookmark the same way they are on a snapshot, through
+// the same "value:source" encoding freezeProperty writes.
+func (d *Dataset) refreshBookmarkProperties(ctx context.Context) error {
+	bfs, srcBootFS, err := getUserProperty(ctx, BootfsProp, d.Name, true)
+	if err != nil {
+		return err
+	}
+	lastUsed, srcLastUsed, err := getUserProperty(ctx, LastUsedProp, d.Name, true)
+	if err != nil {
+		return err
+	}
+	bootfsDatasets, srcBootfsDatasets, err := getUserProperty(ctx, BootfsDatasetsProp, d.Name, true)
+	if err != nil {
+		return err
+	}
+
+	d.DatasetProp = DatasetProp{
+		BootFS:         bfs == "yes",
+		BootfsDatasets: bootfsDatasets,
+		sources: datasetSources{
+			BootFS:         srcBootFS,
+			LastUsed:       srcLastUsed,
+			BootfsDatasets: srcBootfsDatasets,
+		},
+	}
+	if lastUsed != "" {
+		lu, err := strconv.Atoi(lastUsed)
+		if err != nil {
+			return fmt.Errorf(i18n.G("%q property isn't an int: ")+config.ErrorFormat, LastUsedProp, err)
+		}
+		d.LastUsed = lu
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package zfs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/ubuntu/zsys/internal/config"
+	"github.com/ubuntu/zsys/internal/i18n"
+	"github.com/ubuntu/zsys/internal/log"
+)
+
+// ChangeType describes how a path changed between two points in a dataset's
+// history.
+type ChangeType int
+
+const (
+	// Removed means the path existed in the first dataset but not in the second.
+	Removed ChangeType = iota
+	// Created means the path didn't exist in the first dataset but exists in the second.
+	Created
+	// Modified means the path exists in both, with different content or attributes.
+	Modified
+	// Renamed means the path was moved; NewPath holds its new location.
+	Renamed
+)
+
+// InodeType describes the kind of the changed path, as reported by zfs diff.
+type InodeType int
+
+const (
+	// File is a regular file.
+	File InodeType = iota
+	// Directory is a directory.
+	Directory
+	// BlockDevice is a block special device.
+	BlockDevice
+	// CharacterDevice is a character special device.
+	CharacterDevice
+	// SymbolicLink is a symbolic link.
+	SymbolicLink
+	// Socket is a unix domain socket.
+	Socket
+	// NamedPipe is a named pipe (FIFO).
+	NamedPipe
+	// Door is a Solaris/illumos door.
+	Door
+	// EventPort is a Solaris/illumos event port.
+	EventPort
+)
+
+// DiffEntry is one file-level change between two points of a dataset's
+// history, as reported by "zfs diff".
+type DiffEntry struct {
+	ChangeType ChangeType
+	InodeType  InodeType
+	Path       string
+	// NewPath is only set when ChangeType is Renamed.
+	NewPath string
+}
+
+// Diff streams the file-level differences between d and other on the
+// returned channel. other is either another snapshot of the same dataset or,
+// if it's the live filesystem, d itself with a snapshot as other's origin:
+// this is the same model "zfs diff" uses, and answers questions such as
+// "what actually changed between boot environment A and B" or "what would
+// rolling back to snapshot X undo", which complement the hierarchy checks in
+// checkSnapshotHierarchyIntegrity.
+//
+// The channel is closed once the diff is fully streamed or an error occurs;
+// any error is returned once streaming is done.
+func (d Dataset) Diff(ctx context.Context, other *Dataset) (<-chan DiffEntry, <-chan error) {
+	entries := make(chan DiffEntry)
+	errs := make(chan error, 1)
+
+	log.Debugf(ctx, i18n.G("Diffing %q against %q"), d.Name, other.Name)
+
+	cmd := exec.CommandContext(ctx, "zfs", "diff", "-FH", d.Name, other.Name)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		errs <- fmt.Errorf(i18n.G("couldn't get diff output for %q: ")+config.ErrorFormat, d.Name, err)
+		close(entries)
+		close(errs)
+		return entries, errs
+	}
+	if err := cmd.Start(); err != nil {
+		errs <- fmt.Errorf(i18n.G("couldn't start diff for %q: ")+config.ErrorFormat, d.Name, err)
+		close(entries)
+		close(errs)
+		return entries, errs
+	}
+
+	go func() {
+		defer close(entries)
+		defer close(errs)
+
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			e, err := parseDiffLine(scanner.Text())
+			if err != nil {
+				// Stop streaming on the first error instead of looping past
+				// it: errs only has room for one send, and nothing will be
+				// left to drain a second one until entries is closed below —
+				// which we'd never reach if we kept scanning and blocked here.
+				errs <- fmt.Errorf(i18n.G("couldn't parse diff entry for %q: ")+config.ErrorFormat, d.Name, err)
+				_ = cmd.Wait()
+				return
+			}
+			entries <- e
+		}
+		if err := scanner.Err(); err != nil {
+			errs <- fmt.Errorf(i18n.G("couldn't read diff output for %q: ")+config.ErrorFormat, d.Name, err)
+			_ = cmd.Wait()
+			return
+		}
+		if err := cmd.Wait(); err != nil {
+			errs <- fmt.Errorf(i18n.G("diff of %q against %q failed: ")+config.ErrorFormat, d.Name, other.Name, err)
+		}
+	}()
+
+	return entries, errs
+}
+
+// parseDiffLine parses a single tab-separated "zfs diff -FH" line into a
+// DiffEntry. The format is:
+//
+//	<changetype>\t<inodetype>\t<path>[\t<newpath>]
+func parseDiffLine(line string) (DiffEntry, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) < 3 {
+		return DiffEntry{}, fmt.Errorf(i18n.G("unexpected diff line format: %q"), line)
+	}
+
+	var e DiffEntry
+	switch fields[0] {
+	case "-":
+		e.ChangeType = Removed
+	case "+":
+		e.ChangeType = Created
+	case "M":
+		e.ChangeType = Modified
+	case "R":
+		e.ChangeType = Renamed
+	default:
+		return DiffEntry{}, fmt.Errorf(i18n.G("unknown change type %q"), fields[0])
+	}
+
+	switch fields[1] {
+	case "B":
+		e.InodeType = BlockDevice
+	case "C":
+		e.InodeType = CharacterDevice
+	case "/":
+		e.InodeType = Directory
+	case ">":
+		e.InodeType = Door
+	case "|":
+		e.InodeType = NamedPipe
+	case "@":
+		e.InodeType = SymbolicLink
+	case "P":
+		e.InodeType = EventPort
+	case "=":
+		e.InodeType = Socket
+	case "F":
+		e.InodeType = File
+	default:
+		return DiffEntry{}, fmt.Errorf(i18n.G("unknown inode type %q"), fields[1])
+	}
+
+	e.Path = fields[2]
+	if e.ChangeType == Renamed {
+		if len(fields) < 4 {
+			return DiffEntry{}, fmt.Errorf(i18n.G("renamed diff entry %q is missing its new path"), line)
+		}
+		e.NewPath = fields[3]
+	}
+
+	return e, nil
+}
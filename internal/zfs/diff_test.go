@@ -0,0 +1,38 @@
+package zfs
+
+import "testing"
+
+func TestParseDiffLine(t *testing.T) {
+	tests := map[string]struct {
+		line    string
+		want    DiffEntry
+		wantErr bool
+	}{
+		"removed file":         {line: "-\tF\t/some/file", want: DiffEntry{ChangeType: Removed, InodeType: File, Path: "/some/file"}},
+		"created directory":    {line: "+\t/\t/some/dir", want: DiffEntry{ChangeType: Created, InodeType: Directory, Path: "/some/dir"}},
+		"modified file":        {line: "M\tF\t/some/file", want: DiffEntry{ChangeType: Modified, InodeType: File, Path: "/some/file"}},
+		"renamed file":         {line: "R\tF\t/old/path\t/new/path", want: DiffEntry{ChangeType: Renamed, InodeType: File, Path: "/old/path", NewPath: "/new/path"}},
+		"renamed missing dest": {line: "R\tF\t/old/path", wantErr: true},
+		"unknown change type":  {line: "?\tF\t/some/file", wantErr: true},
+		"unknown inode type":   {line: "-\t?\t/some/file", wantErr: true},
+		"too few fields":       {line: "-\tF", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseDiffLine(tc.line)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("parseDiffLine(%q): expected an error, got none", tc.line)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDiffLine(%q): unexpected error: %v", tc.line, err)
+			}
+			if got != tc.want {
+				t.Errorf("parseDiffLine(%q) = %+v, want %+v", tc.line, got, tc.want)
+			}
+		})
+	}
+}
@@ -7,18 +7,18 @@ import (
 	"strconv"
 	"strings"
 
-	libzfs "github.com/bicomsystems/go-libzfs"
 	"github.com/ubuntu/zsys/internal/config"
 	"github.com/ubuntu/zsys/internal/i18n"
 	"github.com/ubuntu/zsys/internal/log"
 )
 
-// RefreshProperties refreshes all the properties for a given dataset and the source of them.
+// RefreshProperties refreshes all the properties for a given dataset and the source of them,
+// through the Backend so it works the same whether we're linked against libzfs or shelling
+// out to zfs(8).
 // for snapshots, we'll take the parent dataset for the mount properties.
-// TODO: dZFS maybe useless (part of d.dZFS)
-func (d *Dataset) RefreshProperties(ctx context.Context, dZFS libzfs.Dataset) error {
+func (d *Dataset) RefreshProperties(ctx context.Context) error {
 	sources := datasetSources{}
-	name := dZFS.Properties[libzfs.DatasetPropName].Value
+	name := d.Name
 
 	var mounted bool
 	var mountpoint, canMount string
@@ -27,35 +27,43 @@ func (d *Dataset) RefreshProperties(ctx context.Context, dZFS libzfs.Dataset) er
 	if d.IsSnapshot {
 		var err error
 
-		mountpoint, sourceMountPoint, err = getUserPropertyFromSys(ctx, SnapshotMountpointProp, dZFS)
+		mountpoint, sourceMountPoint, err = getUserProperty(ctx, SnapshotMountpointProp, name, true)
 		if err != nil {
 			log.Debugf(ctx, i18n.G("%q isn't a zsys snapshot with a valid %q property: %v"), name, SnapshotMountpointProp, err)
 		}
 
-		canMount, sourceCanMount, err = getUserPropertyFromSys(ctx, SnapshotCanmountProp, dZFS)
+		canMount, sourceCanMount, err = getUserProperty(ctx, SnapshotCanmountProp, name, true)
 		if err != nil {
 			log.Debugf(ctx, i18n.G("%q isn't a zsys snapshot with a valid  %q property: %v"), name, SnapshotCanmountProp, err)
 		}
 	} else {
-		mp := dZFS.Properties[libzfs.DatasetPropMountpoint]
+		mp, srcMp, err := currentBackend.DatasetProperty(ctx, name, MountPointProp)
+		if err != nil {
+			return fmt.Errorf(i18n.G("can't get %q property: ")+config.ErrorFormat, MountPointProp, err)
+		}
 
-		p, err := dZFS.Pool()
+		poolRoot, err := currentBackend.PoolRoot(ctx, poolNameOf(name))
 		if err != nil {
 			return fmt.Errorf(i18n.G("can't get associated pool: ")+config.ErrorFormat, err)
 		}
-		poolRoot := p.Properties[libzfs.PoolPropAltroot].Value
-		mountpoint = strings.TrimPrefix(mp.Value, poolRoot)
+		mountpoint = strings.TrimPrefix(mp, poolRoot)
 		if mountpoint == "" {
 			mountpoint = "/"
 		}
-		sourceMountPoint = mp.Source
+		sourceMountPoint = srcMp
 
-		cm := dZFS.Properties[libzfs.DatasetPropCanmount]
-		canMount = cm.Value
-		sourceCanMount = cm.Source
+		cm, srcCm, err := currentBackend.DatasetProperty(ctx, name, CanmountProp)
+		if err != nil {
+			return fmt.Errorf(i18n.G("can't get %q property: ")+config.ErrorFormat, CanmountProp, err)
+		}
+		canMount = cm
+		sourceCanMount = srcCm
 
-		mountedp := dZFS.Properties[libzfs.DatasetPropMounted]
-		if mountedp.Value == "yes" {
+		mountedValue, _, err := currentBackend.DatasetProperty(ctx, name, "mounted")
+		if err != nil {
+			return fmt.Errorf(i18n.G("can't get %q property: ")+config.ErrorFormat, "mounted", err)
+		}
+		if mountedValue == "yes" {
 			mounted = true
 		}
 	}
@@ -81,9 +89,12 @@ func (d *Dataset) RefreshProperties(ctx context.Context, dZFS libzfs.Dataset) er
 		sources.CanMount = ""
 	}
 
-	origin := dZFS.Properties[libzfs.DatasetPropOrigin].Value
+	origin, _, err := currentBackend.DatasetProperty(ctx, name, "origin")
+	if err != nil {
+		return fmt.Errorf(i18n.G("can't get %q property: ")+config.ErrorFormat, "origin", err)
+	}
 
-	bfs, srcBootFS, err := getUserPropertyFromSys(ctx, BootfsProp, dZFS)
+	bfs, srcBootFS, err := getUserProperty(ctx, BootfsProp, name, d.IsSnapshot)
 	if err != nil {
 		return err
 	}
@@ -95,12 +106,15 @@ func (d *Dataset) RefreshProperties(ctx context.Context, dZFS libzfs.Dataset) er
 
 	var lu, srcLastUsed string
 	if !d.IsSnapshot {
-		lu, srcLastUsed, err = getUserPropertyFromSys(ctx, LastUsedProp, dZFS)
+		lu, srcLastUsed, err = getUserProperty(ctx, LastUsedProp, name, false)
 		if err != nil {
 			return err
 		}
 	} else {
-		lu = dZFS.Properties[libzfs.DatasetPropCreation].Value
+		lu, _, err = currentBackend.DatasetProperty(ctx, name, "creation")
+		if err != nil {
+			return fmt.Errorf(i18n.G("can't get %q property: ")+config.ErrorFormat, "creation", err)
+		}
 	}
 	if lu == "" {
 		lu = "0"
@@ -111,13 +125,13 @@ func (d *Dataset) RefreshProperties(ctx context.Context, dZFS libzfs.Dataset) er
 	}
 	sources.LastUsed = srcLastUsed
 
-	lastBootedKernel, srcLastBootedKernel, err := getUserPropertyFromSys(ctx, LastBootedKernelProp, dZFS)
+	lastBootedKernel, srcLastBootedKernel, err := getUserProperty(ctx, LastBootedKernelProp, name, d.IsSnapshot)
 	if err != nil {
 		return err
 	}
 	sources.LastBootedKernel = srcLastBootedKernel
 
-	bootfsDatasets, srcBootfsDatasets, err := getUserPropertyFromSys(ctx, BootfsDatasetsProp, dZFS)
+	bootfsDatasets, srcBootfsDatasets, err := getUserProperty(ctx, BootfsDatasetsProp, name, d.IsSnapshot)
 	if err != nil {
 		return err
 	}
@@ -137,39 +151,45 @@ func (d *Dataset) RefreshProperties(ctx context.Context, dZFS libzfs.Dataset) er
 	return nil
 }
 
-// getUserPropertyFromSys returns the value of a user property and its source from the underlying
-// ZFS system dataset state.
-// It also sanitize the sources to only return "local" or "inherited".
-func getUserPropertyFromSys(ctx context.Context, prop string, dZFS libzfs.Dataset) (value, source string, err error) {
-	name := dZFS.Properties[libzfs.DatasetPropName].Value
+// poolNameOf returns the pool a dataset belongs to, which is always the
+// first element of its name.
+func poolNameOf(name string) string {
+	return strings.SplitN(name, "/", 2)[0]
+}
 
-	p, err := dZFS.GetUserProperty(prop)
+// getUserProperty returns the value of a "com.ubuntu.zsys:*" user property
+// and its source, through the Backend so it works the same whether we're
+// linked against libzfs or shelling out to zfs(8).
+// It also sanitizes the source to only return "local", "default" or "inherited"
+// (or "" when the property isn't set).
+func getUserProperty(ctx context.Context, prop, name string, isSnapshot bool) (value, source string, err error) {
+	v, src, err := currentBackend.DatasetProperty(ctx, name, prop)
 	if err != nil {
 		return "", "", fmt.Errorf(i18n.G("can't get %q property: ")+config.ErrorFormat, prop, err)
 	}
 
 	// User property doesn't exist for this dataset
 	// On undefined user property sources, ZFS returns "-" but the API returns "none" check both for safety
-	if p.Value == "-" && (p.Source == "-" || p.Source == "none") {
+	if v == "-" && (src == "-" || src == "none") {
 		return "", "", nil
 	}
 	// The user property isn't set explicitely on the snapshot (inherited from non snapshot parent): ignore it.
-	if dZFS.IsSnapshot() && p.Source != "local" {
+	if isSnapshot && src != "local" {
 		return "", "", nil
 	}
 
-	if dZFS.IsSnapshot() {
-		log.Debugf(ctx, "property %q on snapshot %q: %q", prop, name, value)
-		idx := strings.LastIndex(p.Value, ":")
+	if isSnapshot {
+		log.Debugf(ctx, "property %q on snapshot %q: %q", prop, name, v)
+		idx := strings.LastIndex(v, ":")
 		if idx < 0 {
 			log.Warningf(ctx, i18n.G("%q isn't a 'value:source' format type for %q"), prop, name)
-			return
+			return "", "", nil
 		}
-		value = p.Value[:idx]
-		source = p.Value[idx+1:]
+		value = v[:idx]
+		source = v[idx+1:]
 	} else {
-		value = p.Value
-		source = p.Source
+		value = v
+		source = src
 		log.Debugf(ctx, "property %q on dataset %q: value: %q source: %q", prop, name, value, source)
 	}
 
@@ -180,35 +200,56 @@ func getUserPropertyFromSys(ctx context.Context, prop string, dZFS libzfs.Datase
 	return value, source, nil
 }
 
-// newDatasetTree returns a Dataset and a populated tree of all its children
-func newDatasetTree(ctx context.Context, dZFS libzfs.Dataset, allDatasets *map[string]*Dataset) (*Dataset, error) {
-	// Skip non file system or snapshot datasets
-	if dZFS.Type == libzfs.DatasetTypeVolume || dZFS.Type == libzfs.DatasetTypeBookmark {
+// newDatasetTree returns a Dataset and a populated tree of all its children,
+// driven entirely through the Backend: it works the same whether we're
+// linked against libzfs or shelling out to zfs(8).
+func newDatasetTree(ctx context.Context, name string, allDatasets *map[string]*Dataset) (*Dataset, error) {
+	kindStr, _, err := currentBackend.DatasetProperty(ctx, name, "type")
+	if err != nil {
+		return nil, fmt.Errorf(i18n.G("couldn't get type of %q: ")+config.ErrorFormat, name, err)
+	}
+	// Skip non file system, snapshot or bookmark datasets
+	if kindStr == "volume" {
 		return nil, nil
 	}
 
-	name := dZFS.Properties[libzfs.DatasetPropName].Value
-	log.Debugf(ctx, i18n.G("New dataNew dataset found: %q"), name)
+	kind := KindFilesystem
+	switch kindStr {
+	case "bookmark":
+		kind = KindBookmark
+	case "snapshot":
+		kind = KindSnapshot
+	}
+
+	log.Debugf(ctx, i18n.G("New dataset found: %q"), name)
+
 	node := Dataset{
 		Name:       name,
-		IsSnapshot: dZFS.IsSnapshot(),
-		dZFS:       dZFS,
+		IsSnapshot: kind == KindSnapshot,
+		Kind:       kind,
+	}
+	// Bookmarks don't carry mount/canmount state and aren't part of the
+	// mountable hierarchy: only refresh our own "com.ubuntu.zsys:*" user
+	// properties on them, the same ones getUserProperty already retrieves
+	// for snapshots.
+	if kind == KindBookmark {
+		if err := node.refreshBookmarkProperties(ctx); err != nil {
+			return nil, fmt.Errorf("couldn't refresh properties of bookmark %q: %v", node.Name, err)
+		}
+		(*allDatasets)[node.Name] = &node
+		return &node, nil
 	}
-	if err := node.RefreshProperties(ctx, dZFS); err != nil {
+	if err := node.RefreshProperties(ctx); err != nil {
 		return nil, fmt.Errorf("couldn't refresh properties of %q: %v", node.Name, err)
 	}
 
+	childNames, err := currentBackend.Children(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf(i18n.G("couldn't list children of %q: ")+config.ErrorFormat, name, err)
+	}
 	var children []*Dataset
-	for i := range dZFS.Children {
-		// WARNING: We are using a single Dataset reference to avoid desync between libzfs.Dataset state and our
-		// internal dZFS elements. libzfs.Dataset doesn't handle Children properly and don't have a way to reach
-		// out to other datasets, like parents, without a full rescan.
-		// We are using our own dZFS as the primary reference object. As we always copy the libzfs.Dataset object,
-		// we are using the same Dataset.list internal C reference pointer, having thus only one dataset in C cache.
-		// This is why we don't .Close() libzfs Datasets after the copy, as it references the same underlying pointed
-		// element.
-		// For security, Children are removed from libzfs in caller.
-		c, err := newDatasetTree(ctx, dZFS.Children[i], allDatasets)
+	for _, cn := range childNames {
+		c, err := newDatasetTree(ctx, cn, allDatasets)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't scan dataset: %v", err)
 		}
@@ -218,7 +259,6 @@ func newDatasetTree(ctx context.Context, dZFS libzfs.Dataset, allDatasets *map[s
 		children = append(children, c)
 	}
 	node.children = children
-	node.dZFS.Children = nil
 
 	// Populate direct access map
 	(*allDatasets)[node.Name] = &node
@@ -243,6 +283,7 @@ func splitSnapshotName(name string) (string, string) {
 //     . the dataset (and its children) has been created after the snapshot was taken -> OK
 //     . the dataset snapshot (and all its children snapshots) have been removed entirely: no way to detect the difference from above -> consider OK
 //   - If one of its children has a snapshot with the same name: clearly a case where something went wrong during snapshot -> error OUT
+//
 // Said differently:
 // if a dataset has a snapshot with a given name, all its parents should have a snapshot with the same name (up to base snapshotName)
 func (d Dataset) checkSnapshotHierarchyIntegrity(snapshotName string, snapshotOnParent bool) error {
@@ -261,7 +302,7 @@ func (d Dataset) checkSnapshotHierarchyIntegrity(snapshotName string, snapshotOn
 	}
 
 	for _, cd := range d.children {
-		if cd.IsSnapshot {
+		if cd.IsSnapshot || cd.Kind == KindBookmark {
 			continue
 		}
 		if err := cd.checkSnapshotHierarchyIntegrity(snapshotName, found); err != nil {
@@ -271,10 +312,14 @@ func (d Dataset) checkSnapshotHierarchyIntegrity(snapshotName string, snapshotOn
 	return nil
 }
 
-// checkNoClone checks that the hierarchy has no clone.
-func (d *Dataset) checkNoClone() error {
-	// TODO: this reopens the pool entirely, so can be a little bit slow. Could be reimplemented ourselves.
-	clones, err := d.dZFS.Clones()
+// checkNoClone checks that the hierarchy has no clone, through the Backend.
+func (d *Dataset) checkNoClone(ctx context.Context) error {
+	// Bookmarks can't be cloned and don't support Clones(): nothing to check.
+	if d.Kind == KindBookmark {
+		return nil
+	}
+
+	clones, err := currentBackend.Clones(ctx, d.Name)
 	if err != nil {
 		return fmt.Errorf(i18n.G("couldn't scan %q for clones"), d.Name)
 	}
@@ -283,7 +328,7 @@ func (d *Dataset) checkNoClone() error {
 	}
 
 	for _, dc := range d.children {
-		if err := dc.checkNoClone(); err != nil {
+		if err := dc.checkNoClone(ctx); err != nil {
 			return err
 		}
 	}
@@ -297,27 +342,29 @@ func (d *Dataset) getPropertyFromName(name string) (value, source string) {
 	return *v, *s
 }
 
-// setProperty abstracts setting value to a zfs native or user property.
+// setProperty abstracts setting value to a zfs native or user property,
+// through the Backend so it works the same whether we're linked against
+// libzfs or shelling out to zfs(8).
 // It refreshes the local object.
-// Note: source isn't taken into account from inheriting on the ZFS dataset
-func (d *Dataset) setProperty(name, value, source string) (err error) {
-	np, up, destV, destS := d.stringToProp(name)
-
-	// TODO: go-libzfs doesn't support "inherited" (C.zfs_prop_inherit).
-	// If source isn't local, we should rather revert to "inherit" which isn't possible atm.
-	// if source == "inherited" …
-
-	// libzfs.Prop is a literal (int) and cannot be created empty and compared directly
-	var empty libzfs.Prop
-	if np != empty {
-		err = d.dZFS.SetProperty(np, value)
+func (d *Dataset) setProperty(ctx context.Context, name, value, source string) (err error) {
+	// Reverting to the inherited/default state isn't a "set", it's "zfs
+	// inherit": route it there instead of writing value verbatim as a local
+	// override. Snapshots keep "value:inherited" frozen below instead.
+	if source == "inherited" && !d.IsSnapshot {
+		return d.InheritProperty(ctx, name, false)
+	}
+
+	isNative, propName, destV, destS := d.stringToProp(name)
+
+	if isNative {
+		err = currentBackend.SetDatasetProperty(ctx, d.Name, propName, value)
 	} else {
 		v := value
 		// we set value:source for values on snapshots to retain original state
 		if d.IsSnapshot {
 			v = fmt.Sprintf("%s:%s", value, source)
 		}
-		err = d.dZFS.SetUserProperty(up, v)
+		err = currentBackend.SetDatasetUserProperty(ctx, d.Name, propName, v)
 	}
 
 	if err != nil {
@@ -352,22 +399,42 @@ func (d *Dataset) setProperty(name, value, source string) (err error) {
 	}
 	*destS = source
 
+	d.refreshInheritedChildren(name, value, oldMountPoint, false)
+
+	return err
+}
+
+// refreshInheritedChildren walks d's children, refreshing their cached value
+// and source to reflect value having just changed on d. It's shared by
+// setProperty and InheritProperty, since reverting a property to its
+// inherited state cascades to children exactly the same way overriding it
+// does.
+//
+// Unless force is set, only children that currently inherit (or have an
+// unset user property for) name are walked: that's right for setProperty,
+// which only ever changes d itself. InheritProperty sets force when recursive
+// is true, because a recursive "zfs inherit -r" reverts every descendant's
+// local override on disk too, not just the ones our cache already thought
+// were inherited.
+func (d *Dataset) refreshInheritedChildren(name, value, oldMountPoint string, force bool) {
 	// Refresh all children that inherits from this property.
 	children := make(chan *Dataset)
 	var getInheritedChildren func(d *Dataset)
 	getInheritedChildren = func(d *Dataset) {
 		for _, c := range d.children {
-			np, _, _, destS := c.stringToProp(name)
 			// We ignore snapshots from inheritance: we only take user properties (even for canmount or mountpoint)
 			// that we have frozen when taking our own snapshots. The other properties will ofc be changed, but
 			// we don't care about them in our local cache.
-			if c.IsSnapshot {
+			if c.IsSnapshot || c.Kind == KindBookmark {
 				continue
 			}
-			// Only take inherited properties OR
-			// default user property (unset user property)
-			if *destS != "inherited" && !(*destS == "" && np == empty) {
-				continue
+			if !force {
+				isNative, _, _, destS := c.stringToProp(name)
+				// Only take inherited properties OR
+				// default user property (unset user property)
+				if *destS != "inherited" && !(*destS == "" && !isNative) {
+					continue
+				}
 			}
 			children <- c
 			getInheritedChildren(c)
@@ -379,16 +446,7 @@ func (d *Dataset) setProperty(name, value, source string) (err error) {
 	}()
 
 	for c := range children {
-		fmt.Println("changing", c.Name)
-		np, _, destV, destS := c.stringToProp(name)
-
-		// Native dataset: we need to refresh dZFS Properties (user properties aren't cached)
-		if np != empty {
-			c.dZFS.Properties[np] = libzfs.Property{
-				Value:  value,
-				Source: c.dZFS.Properties[np].Source,
-			}
-		}
+		_, _, destV, destS := c.stringToProp(name)
 
 		// Refresh dataset object
 		switch name {
@@ -399,10 +457,11 @@ func (d *Dataset) setProperty(name, value, source string) (err error) {
 			}
 			c.BootFS = bootFS
 		case LastUsedProp:
-			if value == "" {
-				value = "0"
+			v := value
+			if v == "" {
+				v = "0"
 			}
-			lastUsed, err := strconv.Atoi(value)
+			lastUsed, err := strconv.Atoi(v)
 			if err != nil {
 				// Shouldn't happen: it's been already checked above from main dataset
 				panic(fmt.Sprintf("%q property isn't an int: %v, while it has already been checked for main dataset and passed", LastUsedProp, err))
@@ -415,18 +474,20 @@ func (d *Dataset) setProperty(name, value, source string) (err error) {
 		}
 		*destS = "inherited"
 	}
-
-	return err
 }
 
-// stringToProp converts a string our object properties.
-// proZfs is empty for user properties. We get pointer on both Dataset object prop and our source
-func (d *Dataset) stringToProp(name string) (nativeProp libzfs.Prop, userProp string, value, simplifiedSource *string) {
+// stringToProp converts a string to our object properties.
+// isNative tells whether name is backed by a native zfs property (through
+// Backend.SetDatasetProperty) rather than one of our "com.ubuntu.zsys:*" user
+// properties (through Backend.SetDatasetUserProperty); userProp is the
+// property name to use with the Backend. We get pointer on both Dataset
+// object prop and our source.
+func (d *Dataset) stringToProp(name string) (isNative bool, userProp string, value, simplifiedSource *string) {
 	userProp = name
 	switch name {
 	case CanmountProp:
 		if !d.IsSnapshot {
-			nativeProp = libzfs.DatasetPropCanmount
+			isNative = true
 		} else {
 			// this should have been called with SnapshotCanmountProp, but map it for the user
 			userProp = SnapshotCanmountProp
@@ -437,7 +498,7 @@ func (d *Dataset) stringToProp(name string) (nativeProp libzfs.Prop, userProp st
 		simplifiedSource = &d.sources.CanMount
 	case MountPointProp:
 		if !d.IsSnapshot {
-			nativeProp = libzfs.DatasetPropMountpoint
+			isNative = true
 		} else {
 			// this should have been called with SnapshotMountpointProp, but map it for the user
 			userProp = SnapshotMountpointProp
@@ -468,7 +529,7 @@ func (d *Dataset) stringToProp(name string) (nativeProp libzfs.Prop, userProp st
 	default:
 		panic(fmt.Sprintf("unsupported property %q", name))
 	}
-	return nativeProp, userProp, value, simplifiedSource
+	return isNative, userProp, value, simplifiedSource
 }
 
 // inverseOrigin inverses on the Dataset object themselves the dependence hierarchy.
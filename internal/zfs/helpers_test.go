@@ -0,0 +1,181 @@
+package zfs
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStringToPropGetPropertyFromName(t *testing.T) {
+	tests := map[string]struct {
+		d         Dataset
+		prop      string
+		wantValue string
+		wantSrc   string
+	}{
+		"canmount on filesystem": {
+			d:         Dataset{DatasetProp: DatasetProp{CanMount: "on", sources: datasetSources{CanMount: "local"}}},
+			prop:      CanmountProp,
+			wantValue: "on",
+			wantSrc:   "local",
+		},
+		"mountpoint on filesystem": {
+			d:         Dataset{DatasetProp: DatasetProp{Mountpoint: "/", sources: datasetSources{Mountpoint: "inherited"}}},
+			prop:      MountPointProp,
+			wantValue: "/",
+			wantSrc:   "inherited",
+		},
+		"bootfs yes": {
+			d:         Dataset{DatasetProp: DatasetProp{BootFS: true, sources: datasetSources{BootFS: "local"}}},
+			prop:      BootfsProp,
+			wantValue: "yes",
+			wantSrc:   "local",
+		},
+		"bootfs no": {
+			d:         Dataset{DatasetProp: DatasetProp{BootFS: false, sources: datasetSources{BootFS: ""}}},
+			prop:      BootfsProp,
+			wantValue: "no",
+			wantSrc:   "",
+		},
+		"lastused": {
+			d:         Dataset{DatasetProp: DatasetProp{LastUsed: 42, sources: datasetSources{LastUsed: "local"}}},
+			prop:      LastUsedProp,
+			wantValue: "42",
+			wantSrc:   "local",
+		},
+		"bootfsdatasets": {
+			d:         Dataset{DatasetProp: DatasetProp{BootfsDatasets: "rpool/ROOT/ubuntu", sources: datasetSources{BootfsDatasets: "local"}}},
+			prop:      BootfsDatasetsProp,
+			wantValue: "rpool/ROOT/ubuntu",
+			wantSrc:   "local",
+		},
+		"lastbootedkernel": {
+			d:         Dataset{DatasetProp: DatasetProp{LastBootedKernel: "5.4.0", sources: datasetSources{LastBootedKernel: "inherited"}}},
+			prop:      LastBootedKernelProp,
+			wantValue: "5.4.0",
+			wantSrc:   "inherited",
+		},
+		"snapshot mountpoint maps to snapshot source": {
+			d:         Dataset{IsSnapshot: true, DatasetProp: DatasetProp{Mountpoint: "/home", sources: datasetSources{Mountpoint: "local"}}},
+			prop:      SnapshotMountpointProp,
+			wantValue: "/home",
+			wantSrc:   "local",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			value, source := tc.d.getPropertyFromName(tc.prop)
+			if value != tc.wantValue {
+				t.Errorf("getPropertyFromName(%q) value = %q, want %q", tc.prop, value, tc.wantValue)
+			}
+			if source != tc.wantSrc {
+				t.Errorf("getPropertyFromName(%q) source = %q, want %q", tc.prop, source, tc.wantSrc)
+			}
+		})
+	}
+}
+
+func TestStringToPropPanicsOnUnknownProperty(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected stringToProp to panic on an unsupported property name")
+		}
+	}()
+	(&Dataset{}).getPropertyFromName("not.a.real.property")
+}
+
+// fakeTreeProp is a (value, source) pair as DatasetProperty returns them.
+type fakeTreeProp struct {
+	value, source string
+}
+
+// fakeTreeBackend is a minimal Backend fixture driving a small, fixed
+// dataset tree: a filesystem with one child snapshot and one child
+// bookmark, the same shape newDatasetTree's recursive walk has to handle
+// through Children regardless of which Backend implementation is behind it.
+type fakeTreeBackend struct {
+	Backend
+	children map[string][]string
+	props    map[string]map[string]fakeTreeProp
+}
+
+func (f fakeTreeBackend) DatasetProperty(ctx context.Context, name, prop string) (value, source string, err error) {
+	p, ok := f.props[name][prop]
+	if !ok {
+		return "-", "-", nil
+	}
+	return p.value, p.source, nil
+}
+
+func (f fakeTreeBackend) Children(ctx context.Context, name string) ([]string, error) {
+	return f.children[name], nil
+}
+
+func (f fakeTreeBackend) Clones(ctx context.Context, name string) ([]string, error) {
+	return nil, nil
+}
+
+func (f fakeTreeBackend) PoolRoot(ctx context.Context, pool string) (string, error) {
+	return "", nil
+}
+
+func newFakeTreeBackend() fakeTreeBackend {
+	const (
+		fs   = "rpool/ROOT/ubuntu"
+		snap = "rpool/ROOT/ubuntu@snap1"
+		bm   = "rpool/ROOT/ubuntu#bm1"
+	)
+	return fakeTreeBackend{
+		children: map[string][]string{
+			fs:   {snap, bm},
+			snap: nil,
+		},
+		props: map[string]map[string]fakeTreeProp{
+			fs: {
+				"type":         {"filesystem", "-"},
+				MountPointProp: {"/", "local"},
+				CanmountProp:   {"on", "local"},
+				"mounted":      {"yes", "-"},
+				"origin":       {"-", "-"},
+			},
+			snap: {
+				"type":     {"snapshot", "-"},
+				"origin":   {"-", "-"},
+				"creation": {"1627000000", "-"},
+			},
+			bm: {
+				"type":   {"bookmark", "-"},
+				"origin": {"-", "-"},
+			},
+		},
+	}
+}
+
+func TestNewDatasetTreeWalksSnapshotsAndBookmarks(t *testing.T) {
+	withBackend(newFakeTreeBackend(), func() {
+		allDatasets := make(map[string]*Dataset)
+		root, err := newDatasetTree(context.Background(), "rpool/ROOT/ubuntu", &allDatasets)
+		if err != nil {
+			t.Fatalf("newDatasetTree returned an error: %v", err)
+		}
+
+		for name, wantKind := range map[string]Kind{
+			"rpool/ROOT/ubuntu":       KindFilesystem,
+			"rpool/ROOT/ubuntu@snap1": KindSnapshot,
+			"rpool/ROOT/ubuntu#bm1":   KindBookmark,
+		} {
+			d, ok := allDatasets[name]
+			if !ok {
+				t.Errorf("expected %q to be in allDatasets, it wasn't", name)
+				continue
+			}
+			if d.Kind != wantKind {
+				t.Errorf("%q has Kind %v, want %v", name, d.Kind, wantKind)
+			}
+		}
+
+		if err := root.checkNoClone(context.Background()); err != nil {
+			t.Errorf("checkNoClone on a tree with no clones returned an error: %v", err)
+		}
+	})
+}
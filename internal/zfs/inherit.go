@@ -0,0 +1,75 @@
+package zfs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/ubuntu/zsys/internal/config"
+	"github.com/ubuntu/zsys/internal/i18n"
+	"github.com/ubuntu/zsys/internal/log"
+)
+
+// InheritProperty reverts name to its inherited (or default, for a property
+// with no parent to inherit from) value on d, through the Backend, and
+// refreshes the local object and the mountpoint hierarchy of its children
+// exactly the way setProperty does for its "inherited" propagation loop —
+// boot environment cleanup relies on this to truly restore default/parent
+// semantics after a machine transition, rather than being stuck with the
+// locally overridden value forever.
+//
+// It handles both native properties (those stringToProp reports as native) and our
+// own zsys user properties (BootfsProp, LastUsedProp, LastBootedKernelProp,
+// BootfsDatasetsProp).
+func (d *Dataset) InheritProperty(ctx context.Context, name string, recursive bool) error {
+	log.Debugf(ctx, i18n.G("Inheriting %q on %q (recursive: %v)"), name, d.Name, recursive)
+
+	_, propName, destV, destS := d.stringToProp(name)
+
+	if err := currentBackend.Inherit(ctx, d.Name, propName, recursive); err != nil {
+		return fmt.Errorf(i18n.G("couldn't inherit %q on %q: ")+config.ErrorFormat, name, d.Name, err)
+	}
+
+	value, source, err := currentBackend.DatasetProperty(ctx, d.Name, propName)
+	if err != nil {
+		return fmt.Errorf(i18n.G("couldn't read %q back on %q after inheriting it: ")+config.ErrorFormat, name, d.Name, err)
+	}
+	if value == "-" && (source == "-" || source == "none") {
+		// No ancestor sets this user property: it's now unset, which
+		// getUserProperty reports as an empty value and source.
+		value, source = "", ""
+	} else if source != "local" && source != "default" {
+		source = "inherited"
+	}
+
+	// In case the mountpoint hierarchy moves, we need to translate it for
+	// children. Store the initial mountpoint path.
+	var oldMountPoint string
+	switch name {
+	case BootfsProp:
+		d.BootFS = value == "yes"
+	case LastUsedProp:
+		v := value
+		if v == "" {
+			v = "0"
+		}
+		lastUsed, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf(i18n.G("%q property isn't an int: ")+config.ErrorFormat, LastUsedProp, err)
+		}
+		d.LastUsed = lastUsed
+	case MountPointProp:
+		oldMountPoint = *destV
+		*destV = value
+	default:
+		*destV = value
+	}
+	*destS = source
+
+	// A recursive inherit reverts every descendant's local override on disk,
+	// not just the ones our cache already thought were inherited: force the
+	// cascade to walk (and overwrite) all of them in that case.
+	d.refreshInheritedChildren(name, value, oldMountPoint, recursive)
+
+	return nil
+}
@@ -0,0 +1,197 @@
+package zfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/ubuntu/zsys/internal/config"
+	"github.com/ubuntu/zsys/internal/i18n"
+	"github.com/ubuntu/zsys/internal/log"
+)
+
+// receiveResumeTokenProp is the native zfs property zfs(8) itself populates
+// on a dataset left behind by an interrupted "zfs receive -s", so that a
+// later "zfs send -t <token>" can pick the transfer back up.
+const receiveResumeTokenProp = "receive_resume_token"
+
+// SendOptions controls how Send() serializes a dataset or snapshot range.
+type SendOptions struct {
+	// From is the name of the snapshot or bookmark to send an incremental
+	// stream from. It's either an ancestor snapshot/bookmark on the same
+	// dataset (-i) or, when Incremental is also set, the common
+	// snapshot/bookmark anchoring a -I stream. A bookmark can be used here
+	// even after the snapshot it was created from (see CreateBookmark) was
+	// destroyed.
+	From string
+	// Incremental requests a -I stream, replaying every intermediary
+	// snapshot between From and the dataset named in Send(), instead of
+	// just the end state (-i).
+	Incremental bool
+	// Replicate requests a -R send of the dataset's entire descendant
+	// hierarchy (children, clones and their snapshots).
+	Replicate bool
+	// Properties requests a -p send, embedding dataset properties
+	// (including our own "com.ubuntu.zsys:*" user properties) in the
+	// stream so the receiver can rebuild them without a separate pass.
+	Properties bool
+}
+
+// Send serializes name (a snapshot, or a dataset hierarchy when o.Replicate
+// is set) as a ZFS send stream written to w.
+func (z Zfs) Send(ctx context.Context, name string, w io.Writer, o SendOptions) (err error) {
+	log.Debugf(ctx, i18n.G("Sending %q, options: %+v"), name, o)
+
+	if err := runSend(ctx, sendArgs(name, o, false), w); err != nil {
+		return fmt.Errorf(i18n.G("couldn't send %q: ")+config.ErrorFormat, name, err)
+	}
+
+	return nil
+}
+
+// SendSize estimates, without transferring any data, the size in bytes of the
+// stream that Send would produce for name with the same options.
+func (z Zfs) SendSize(ctx context.Context, name string, o SendOptions) (int64, error) {
+	var buf bytes.Buffer
+	if err := runSend(ctx, sendArgs(name, o, true), &buf); err != nil {
+		return 0, fmt.Errorf(i18n.G("couldn't estimate send size of %q: ")+config.ErrorFormat, name, err)
+	}
+
+	// zfs send -nP prints a single "size\t<bytes>" line.
+	for _, line := range strings.Split(buf.String(), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 2 || fields[0] != "size" {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf(i18n.G("couldn't parse size estimate for %q: ")+config.ErrorFormat, name, err)
+		}
+		return size, nil
+	}
+
+	return 0, fmt.Errorf(i18n.G("no size estimate returned for %q"), name)
+}
+
+// resumeTokenFor returns the resume token zfs(8) itself left in the native
+// receive_resume_token property of name after an interrupted resumable
+// receive, or "" if there is none (the receive wasn't resumable, completed
+// normally, or name doesn't exist).
+func resumeTokenFor(ctx context.Context, name string) (string, error) {
+	token, _, err := currentBackend.DatasetProperty(ctx, name, receiveResumeTokenProp)
+	if err != nil {
+		return "", fmt.Errorf(i18n.G("couldn't read %q on %q: ")+config.ErrorFormat, receiveResumeTokenProp, name, err)
+	}
+	if token == "-" {
+		token = ""
+	}
+	return token, nil
+}
+
+// ResumeSend resumes a previously interrupted send of name, using the resume
+// token zfs(8) recorded in its native receive_resume_token property.
+func (z Zfs) ResumeSend(ctx context.Context, name string, w io.Writer) error {
+	token, err := resumeTokenFor(ctx, name)
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return fmt.Errorf(i18n.G("%q has no resumable send in progress"), name)
+	}
+
+	if err := runSend(ctx, []string{"send", "-t", token}, w); err != nil {
+		return fmt.Errorf(i18n.G("couldn't resume send of %q: ")+config.ErrorFormat, name, err)
+	}
+
+	return nil
+}
+
+// Receive reconstructs a dataset or snapshot hierarchy from a ZFS send
+// stream read from r, landing it under name. The receive runs inside its own
+// nestedTransaction: on success, every received dataset is registered in
+// allDatasets and has its tree position and properties refreshed, so the
+// rest of the state machinery sees it immediately. If resumable is true and
+// the transfer is interrupted, zfs(8) itself leaves the partial dataset
+// behind with its native receive_resume_token property set; Receive detects
+// that and reports it so the caller knows ResumeSend can pick the transfer
+// back up, instead of just surfacing a generic failure.
+func (z *Zfs) Receive(ctx context.Context, name string, r io.Reader, resumable bool) (err error) {
+	log.Debugf(ctx, i18n.G("Receiving into %q"), name)
+
+	t, err := z.newNestedTransaction(ctx)
+	if err != nil {
+		return err
+	}
+	defer t.done(&err)
+
+	args := []string{"receive"}
+	if resumable {
+		args = append(args, "-s")
+	}
+	args = append(args, name)
+
+	cmd := exec.CommandContext(ctx, "zfs", args...)
+	cmd.Stdin = r
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		receiveErr := fmt.Errorf(i18n.G("couldn't receive %q: ")+config.ErrorFormat, name, fmt.Errorf("%s: %v", stderr.String(), err))
+		if !resumable {
+			return receiveErr
+		}
+		// zfs(8) only leaves a resume token behind when the receive was
+		// started with -s: check for it rather than assuming every failure
+		// is resumable.
+		if token, tErr := resumeTokenFor(ctx, name); tErr == nil && token != "" {
+			return fmt.Errorf(i18n.G("receive of %q was interrupted but can be resumed with ResumeSend: ")+config.ErrorFormat, name, err)
+		}
+		return receiveErr
+	}
+
+	if _, err := newDatasetTree(ctx, name, &t.Zfs.allDatasets); err != nil {
+		return fmt.Errorf(i18n.G("couldn't rebuild dataset tree for received %q: ")+config.ErrorFormat, name, err)
+	}
+
+	return nil
+}
+
+// sendArgs builds the zfs(8) send argument list for name from o. When dryRun
+// is true, -nP is added so the command only reports the stream's estimated
+// size instead of writing it.
+func sendArgs(name string, o SendOptions, dryRun bool) []string {
+	args := []string{"send"}
+	if dryRun {
+		args = append(args, "-nP")
+	}
+	if o.Replicate {
+		args = append(args, "-R")
+	}
+	if o.Properties {
+		args = append(args, "-p")
+	}
+	if o.From != "" {
+		if o.Incremental {
+			args = append(args, "-I", o.From)
+		} else {
+			args = append(args, "-i", o.From)
+		}
+	}
+	return append(args, name)
+}
+
+// runSend execs "zfs <args…>" with its stdout piped to w, returning stderr
+// content wrapped in the error on failure.
+func runSend(ctx context.Context, args []string, w io.Writer) error {
+	cmd := exec.CommandContext(ctx, "zfs", args...)
+	cmd.Stdout = w
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %v", stderr.String(), err)
+	}
+	return nil
+}
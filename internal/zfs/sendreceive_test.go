@@ -0,0 +1,104 @@
+package zfs
+
+import (
+	"context"
+	"io"
+	"testing"
+)
+
+// fakeResumeBackend implements Backend enough to drive resumeTokenFor and
+// ResumeSend: everything else panics if called, since these tests don't
+// exercise it.
+type fakeResumeBackend struct {
+	Backend
+	props map[string]string
+}
+
+func (f fakeResumeBackend) DatasetProperty(ctx context.Context, name, prop string) (value, source string, err error) {
+	v, ok := f.props[name+"#"+prop]
+	if !ok {
+		return "-", "-", nil
+	}
+	return v, "local", nil
+}
+
+func withBackend(b Backend, f func()) {
+	orig := currentBackend
+	currentBackend = b
+	defer func() { currentBackend = orig }()
+	f()
+}
+
+func TestResumeTokenForInterruptedReceive(t *testing.T) {
+	// Simulates the state zfs(8) leaves behind after "zfs receive -s" was
+	// interrupted: the partial dataset carries a native receive_resume_token.
+	withBackend(fakeResumeBackend{props: map[string]string{
+		"rpool/ROOT/ubuntu#" + receiveResumeTokenProp: "1-a1b2c3d4",
+	}}, func() {
+		token, err := resumeTokenFor(context.Background(), "rpool/ROOT/ubuntu")
+		if err != nil {
+			t.Fatalf("resumeTokenFor returned an error: %v", err)
+		}
+		if token != "1-a1b2c3d4" {
+			t.Errorf("got token %q, want %q", token, "1-a1b2c3d4")
+		}
+	})
+}
+
+func TestResumeTokenForCompletedReceive(t *testing.T) {
+	// A dataset that was never interrupted has no resume token: zfs reports
+	// it as "-".
+	withBackend(fakeResumeBackend{props: map[string]string{}}, func() {
+		token, err := resumeTokenFor(context.Background(), "rpool/ROOT/ubuntu")
+		if err != nil {
+			t.Fatalf("resumeTokenFor returned an error: %v", err)
+		}
+		if token != "" {
+			t.Errorf("got token %q, want empty", token)
+		}
+	})
+}
+
+func TestResumeSendWithoutInterruptedReceiveFails(t *testing.T) {
+	withBackend(fakeResumeBackend{props: map[string]string{}}, func() {
+		err := Zfs{}.ResumeSend(context.Background(), "rpool/ROOT/ubuntu", io.Discard)
+		if err == nil {
+			t.Fatal("expected an error resuming a dataset with no interrupted receive, got none")
+		}
+	})
+}
+
+func TestSendArgs(t *testing.T) {
+	tests := map[string]struct {
+		name   string
+		o      SendOptions
+		dryRun bool
+		want   []string
+	}{
+		"plain send":              {name: "rpool/ROOT/ubuntu@snap", want: []string{"send", "rpool/ROOT/ubuntu@snap"}},
+		"dry run":                 {name: "rpool/ROOT/ubuntu@snap", dryRun: true, want: []string{"send", "-nP", "rpool/ROOT/ubuntu@snap"}},
+		"replicate":               {name: "rpool/ROOT/ubuntu@snap", o: SendOptions{Replicate: true}, want: []string{"send", "-R", "rpool/ROOT/ubuntu@snap"}},
+		"with properties":         {name: "rpool/ROOT/ubuntu@snap", o: SendOptions{Properties: true}, want: []string{"send", "-p", "rpool/ROOT/ubuntu@snap"}},
+		"incremental from":        {name: "rpool/ROOT/ubuntu@snap2", o: SendOptions{From: "rpool/ROOT/ubuntu@snap1"}, want: []string{"send", "-i", "rpool/ROOT/ubuntu@snap1", "rpool/ROOT/ubuntu@snap2"}},
+		"incremental stream from": {name: "rpool/ROOT/ubuntu@snap2", o: SendOptions{From: "rpool/ROOT/ubuntu@snap1", Incremental: true}, want: []string{"send", "-I", "rpool/ROOT/ubuntu@snap1", "rpool/ROOT/ubuntu@snap2"}},
+		"replicate dry run with properties": {
+			name: "rpool/ROOT/ubuntu@snap", dryRun: true, o: SendOptions{Replicate: true, Properties: true},
+			want: []string{"send", "-nP", "-R", "-p", "rpool/ROOT/ubuntu@snap"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := sendArgs(tc.name, tc.o, tc.dryRun)
+			if len(got) != len(tc.want) {
+				t.Fatalf("sendArgs() = %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("sendArgs() = %v, want %v", got, tc.want)
+					break
+				}
+			}
+		})
+	}
+}